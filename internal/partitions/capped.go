@@ -0,0 +1,134 @@
+package partitions
+
+import (
+	"context"
+
+	"github.com/10gen/migration-verifier/internal/logger"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// cappedMonotonicitySampleSize is the number of leading documents, in `$natural` order, sampled to
+// confirm that a candidate CappedResumeField is actually monotonic before we trust it to resume a
+// scan.
+const cappedMonotonicitySampleSize = 1000
+
+// SetCappedResumeField validates that field is monotonically non-decreasing in `$natural` order
+// on coll, and, if so, sets p.CappedResumeField to field so that future FindCmd calls can resume
+// an interrupted scan instead of restarting from the beginning of the collection. If field isn't
+// monotonic, p.CappedResumeField is left unset, a warning is logged, and callers fall back to a
+// full rescan on every interruption.
+func (p *Partition) SetCappedResumeField(ctx context.Context, log *logger.Logger, coll *mongo.Collection, field string) error {
+	if !p.IsCapped {
+		return errors.Errorf("cannot set a capped resume field on a non-capped partition %s", p)
+	}
+
+	monotonic, err := isMonotonicInNaturalOrder(ctx, coll, field)
+	if err != nil {
+		return errors.Wrapf(err, "failed to validate capped resume field %q", field)
+	}
+
+	if !monotonic {
+		log.Warn().
+			Str("namespace", p.Ns.DB+"."+p.Ns.Coll).
+			Str("field", field).
+			Msg("capped resume field is not monotonic in $natural order; falling back to full rescan on interruption")
+		p.CappedResumeField = ""
+		return nil
+	}
+
+	p.CappedResumeField = field
+	return nil
+}
+
+// isMonotonicInNaturalOrder samples the first cappedMonotonicitySampleSize documents of coll in
+// `$natural` order and reports whether field's value is non-decreasing across that sample.
+func isMonotonicInNaturalOrder(ctx context.Context, coll *mongo.Collection, field string) (bool, error) {
+	findOpts := options.Find().
+		SetSort(bson.D{{"$natural", 1}}).
+		SetLimit(cappedMonotonicitySampleSize).
+		SetProjection(bson.D{{field, 1}})
+
+	cursor, err := coll.Find(ctx, bson.D{}, findOpts)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to sample collection in $natural order")
+	}
+	defer cursor.Close(ctx)
+
+	var prev bson.RawValue
+	haveSeen := false
+	for cursor.Next(ctx) {
+		val, err := cursor.Current.LookupErr(field)
+		if err != nil {
+			return false, errors.Wrapf(err, "document is missing field %q", field)
+		}
+
+		if haveSeen {
+			cmp, err := compareBSONValues(prev, val)
+			if err != nil {
+				return false, err
+			}
+			if cmp > 0 {
+				return false, nil
+			}
+		}
+
+		prev = val
+		haveSeen = true
+	}
+	if err := cursor.Err(); err != nil {
+		return false, errors.Wrap(err, "cursor error while sampling $natural order")
+	}
+
+	return true, nil
+}
+
+// compareBSONValues compares two same-typed BSON scalar values, returning <0, 0, or >0. It
+// supports the numeric and time/timestamp types expected of a capped resume field such as "ts".
+func compareBSONValues(a, b bson.RawValue) (int, error) {
+	switch a.Type {
+	case bson.TypeTimestamp:
+		at, ai := a.Timestamp()
+		bt, bi := b.Timestamp()
+		if at != bt {
+			return int(at) - int(bt), nil
+		}
+		return int(ai) - int(bi), nil
+	case bson.TypeDateTime:
+		return int(a.DateTime() - b.DateTime()), nil
+	case bson.TypeInt32:
+		ai, bi := a.Int32(), b.Int32()
+		switch {
+		case ai < bi:
+			return -1, nil
+		case ai > bi:
+			return 1, nil
+		default:
+			return 0, nil
+		}
+	case bson.TypeInt64:
+		diff := a.Int64() - b.Int64()
+		switch {
+		case diff < 0:
+			return -1, nil
+		case diff > 0:
+			return 1, nil
+		default:
+			return 0, nil
+		}
+	case bson.TypeDouble:
+		diff := a.Double() - b.Double()
+		switch {
+		case diff < 0:
+			return -1, nil
+		case diff > 0:
+			return 1, nil
+		default:
+			return 0, nil
+		}
+	default:
+		return 0, errors.Errorf("unsupported BSON type %s for capped resume field comparison", a.Type)
+	}
+}
@@ -2,6 +2,7 @@ package partitions
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/10gen/migration-verifier/internal/logger"
 	"github.com/10gen/migration-verifier/internal/util"
@@ -12,9 +13,31 @@ import (
 
 // PartitionKey represents the _id of a partition document stored in the destination.
 type PartitionKey struct {
-	SourceUUID  util.UUID   `bson:"srcUUID"`
-	MongosyncID string      `bson:"id"`
-	Lower       interface{} `bson:"lowerBound"`
+	SourceUUID  util.UUID `bson:"srcUUID"`
+	MongosyncID string    `bson:"id"`
+	Lower       bson.D    `bson:"lowerBound"`
+
+	// KeyFields lists the fields the partition is bounded on, in key order. When unset, it
+	// defaults to []string{"_id"}.
+	//
+	// Note this does not make partitions planned before KeyFields existed load-compatible with
+	// this type: Lower (and Partition.Upper) changed from a bare interface{} _id value to a
+	// bson.D keyed by field name, which is a different persisted shape. Any partition documents
+	// stored under the old shape will fail to decode into this struct and must be re-planned.
+	KeyFields []string `bson:"keyFields,omitempty"`
+
+	// DestUUID is the destination collection's UUID, captured at partition planning time
+	// alongside SourceUUID. Partition.Verify compares both against a fresh listCollections call
+	// to detect a collection recreated mid-run on either side.
+	DestUUID util.UUID `bson:"dstUUID,omitempty"`
+}
+
+// fields returns the key fields to partition on, defaulting to {"_id"} when KeyFields is unset.
+func (k *PartitionKey) fields() []string {
+	if len(k.KeyFields) == 0 {
+		return []string{"_id"}
+	}
+	return k.KeyFields
 }
 
 // Namespace stores the database and collection name of the namespace being copied.
@@ -23,21 +46,63 @@ type Namespace struct {
 	Coll string `bson:"coll"`
 }
 
-// Partition represents a range of documents in a namespace, bounded by the _id field.
+// Partition represents a range of documents in a namespace, bounded by the fields in
+// Key.KeyFields (the `_id` field, by default, but a shard key or other index's fields when the
+// partition was planned from chunk boundaries).
 //
 // A valid partition must have a non-nil lower bound (in its PartitionKey) and a non-nil upper bound.
 type Partition struct {
 	Key PartitionKey `bson:"_id"`
 	Ns  *Namespace   `bson:"namespace"`
 
-	// The upper index key bound for the partition.
-	Upper interface{} `bson:"upperBound"`
+	// The upper index key bound for the partition, one value per field in Key.KeyFields, in the
+	// same order.
+	Upper bson.D `bson:"upperBound"`
 
 	// Set to true if the partition is for a capped collection. If so, this partition's
 	// upper/lower bounds should be set to the minKey and maxKey of the collection.
 	IsCapped bool `bson:"isCapped"`
+
+	// CappedResumeField names the field that identifies a document's position in `$natural`
+	// order for a capped collection, e.g. "ts" for an oplog-style collection. It's ignored
+	// unless IsCapped is true. When set, FindCmd can resume an interrupted `$natural` scan from
+	// the last observed value instead of restarting from the beginning of the collection.
+	CappedResumeField string `bson:"cappedResumeField,omitempty"`
+
+	// Strategy controls how filter() encodes the partition's range bounds into a query. The
+	// zero value, BoundExpr, is always correct but disables index bounds on many server
+	// versions; planners set BoundNative when they can prove it's safe. See BoundStrategy.
+	Strategy BoundStrategy `bson:"boundStrategy,omitempty"`
+
+	// UpperExclusive, if true, excludes documents whose key tuple exactly equals Upper. Planners
+	// that emit adjacent partitions sharing a boundary value (e.g. consecutive $bucketAuto
+	// buckets, or consecutive chunks) set this on every partition but the last so that, combined
+	// with the always-inclusive Lower, the partitions are truly disjoint.
+	UpperExclusive bool `bson:"upperExclusive,omitempty"`
 }
 
+// BoundStrategy selects how Partition.filter() encodes a partition's range bounds into a query
+// predicate.
+type BoundStrategy int
+
+const (
+	// BoundExpr wraps each bound in $expr+$literal. This is always correct, including across
+	// partitions whose lower and upper bounds have different BSON types, but $expr disables
+	// index bounds on many server versions and roughly doubles CPU on scans of large partitions.
+	BoundExpr BoundStrategy = iota
+
+	// BoundNative emits a plain range predicate, e.g. {_id: {$gte: L, $lte: U}}. This lets the
+	// server use index bounds, but is only correct when every value of the key field(s) being
+	// compared shares a single BSON type with L and U, since native comparison operators are
+	// subject to type bracketing.
+	BoundNative
+
+	// BoundTypeBracketed emits a $or of per-BSON-type native range predicates covering the type
+	// range spanned by L and U. It's a middle ground: slower than BoundNative but still index-
+	// bound-eligible, for partitions whose bounds span more than one BSON type.
+	BoundTypeBracketed
+)
+
 // String returns a string representation of the partition.
 func (p *Partition) String() string {
 	return fmt.Sprintf(
@@ -56,7 +121,16 @@ func (p *Partition) GetUpperBoundString() string {
 }
 
 // getIndexKeyBoundString returns the string representation of the given index key bound.
-func (p *Partition) getIndexKeyBoundString(bound interface{}) string {
+func (p *Partition) getIndexKeyBoundString(bound bson.D) string {
+	parts := make([]string, 0, len(bound))
+	for _, e := range bound {
+		parts = append(parts, fmt.Sprintf("%s: %s", e.Key, p.getIndexKeyValueString(e.Value)))
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+// getIndexKeyValueString returns the string representation of a single index key bound value.
+func (p *Partition) getIndexKeyValueString(bound interface{}) string {
 	switch b := bound.(type) {
 	case bson.RawValue:
 		return b.String()
@@ -70,10 +144,12 @@ func (p *Partition) getIndexKeyBoundString(bound interface{}) string {
 }
 
 // lowerBoundFromCurrent takes the current value of a cursor and returns the value to save as
-// the lower bound for the cursor. For capped collections, this is `nil`. For others it's the
-// value of the `_id` field.
-func (p *Partition) lowerBoundFromCurrent(current bson.Raw) (interface{}, error) {
-	if p.IsCapped {
+// the lower bound for the cursor. For capped collections without a CappedResumeField, this is
+// `nil`, since `$natural` order can't be resumed by value. For capped collections with a
+// CappedResumeField, it's that field's value, to resume the `$natural` scan. For others it's the
+// values of Key.fields(), in key order.
+func (p *Partition) lowerBoundFromCurrent(current bson.Raw) (bson.D, error) {
+	if p.IsCapped && p.CappedResumeField == "" {
 		return nil, nil
 	}
 
@@ -87,22 +163,45 @@ func (p *Partition) lowerBoundFromCurrent(current bson.Raw) (interface{}, error)
 		return nil, errors.Wrap(err, "error unmarshaling raw document to bson.M")
 	}
 
-	if id, ok := doc["_id"]; ok {
-		return id, nil
+	if p.IsCapped {
+		val, ok := doc[p.CappedResumeField]
+		if !ok {
+			return nil, errors.Errorf("could not find capped resume field %q in the raw document", p.CappedResumeField)
+		}
+		return bson.D{{p.CappedResumeField, val}}, nil
 	}
 
-	return nil, errors.New("could not find an '_id' element in the raw document")
+	lower := make(bson.D, 0, len(p.Key.fields()))
+	for _, field := range p.Key.fields() {
+		val, ok := doc[field]
+		if !ok {
+			return nil, errors.Errorf("could not find a %q element in the raw document", field)
+		}
+		lower = append(lower, bson.E{Key: field, Value: val})
+	}
+
+	return lower, nil
 }
 
 // FindCmd constructs the Find command for reading documents from the partition. For capped
-// collections, the sort order will be `$natural` and the `lowerBound` argument is ignored. For
-// all other collections, the collection will be sorted by the `_id` field. The `lowerBound`
-// argument will determine the starting point for the find. If it is `nil`, then the value of
-// `p.Key.Lower`.
+// collections, the sort order will be `$natural`. If the partition has a CappedResumeField and
+// resumeAfter is non-nil, the find is additionally filtered on that field so an interrupted scan
+// can resume instead of restarting from the beginning of the collection; otherwise the `_id`
+// filter is deliberately excluded to preserve natural insertion order. For all other collections,
+// the collection will be sorted by `p.Key.fields()` (the `_id` field, unless the partition was
+// planned on a shard key or other index), and the find's range starts at `p.Key.Lower` and ends
+// at `p.Upper`.
 func (p *Partition) FindCmd(
 	// TODO (REP-1281)
 	logger *logger.Logger,
 	startAt *primitive.Timestamp,
+	// resumeAfter is the CappedResumeField value saved from the last document of a previous,
+	// interrupted scan of this (capped) partition. It's ignored for non-capped partitions.
+	resumeAfter bson.D,
+	// strategyOverride lets a caller use a different BoundStrategy than p.Strategy for this one
+	// call, e.g. for A/B testing BoundNative against BoundExpr in production. nil means use
+	// p.Strategy.
+	strategyOverride *BoundStrategy,
 	// We only use this for testing.
 	batchSize ...int,
 ) bson.D {
@@ -129,39 +228,318 @@ func (p *Partition) FindCmd(
 		// exclude the ID filter to ensure that documents are inserted in the correct order.
 		sort := bson.E{"sort", bson.D{{"$natural", 1}}}
 		findCmd = append(findCmd, sort)
+
+		if p.CappedResumeField != "" && len(resumeAfter) > 0 {
+			var resumeVal interface{}
+			for _, e := range resumeAfter {
+				if e.Key == p.CappedResumeField {
+					resumeVal = e.Value
+				}
+			}
+			findCmd = append(findCmd, bson.E{"filter", bson.D{
+				{p.CappedResumeField, bson.D{{"$gte", resumeVal}}},
+			}})
+		}
 	} else {
-		// For non-capped collections, the cursor should use the ID filter and the _id index.
-		// Get the bounded query filter from the partition to be used in the Find command.
-		filter := p.filter()
+		// For non-capped collections, the cursor should use the range filter and a hint matching
+		// the partition's key fields (the _id index, unless the partition was planned on a shard
+		// key or other compound index).
+		strategy := p.Strategy
+		if strategyOverride != nil {
+			strategy = *strategyOverride
+		}
+		filter := p.filter(strategy)
 		boundedQueryFilter := bson.E{"filter", filter}
 		findCmd = append(findCmd, boundedQueryFilter)
 
-		hint := bson.E{"hint", bson.D{{"_id", 1}}}
+		hint := bson.E{"hint", p.hint()}
 		findCmd = append(findCmd, hint)
 	}
 
 	return findCmd
 }
 
-// filter returns a range filter on _id to be used in a Find query for the
-// partition.
-func (p *Partition) filter() bson.D {
-	// We use $expr to avoid type bracketing and allow comparison of different _id types,
-	// and $literal to avoid MQL injection from an _id's value.
+// hint returns the index hint matching the partition's key fields, in key order.
+func (p *Partition) hint() bson.D {
+	fields := p.Key.fields()
+	hint := make(bson.D, 0, len(fields))
+	for _, field := range fields {
+		hint = append(hint, bson.E{Key: field, Value: 1})
+	}
+	return hint
+}
+
+// filter returns a compound range filter over the partition's key fields to be used in a Find
+// query for the partition, encoded according to strategy. Fields are compared in key order.
+func (p *Partition) filter(strategy BoundStrategy) bson.D {
+	switch strategy {
+	case BoundNative:
+		return p.nativeFilter()
+	case BoundTypeBracketed:
+		return p.typeBracketedFilter()
+	default:
+		return p.exprFilter()
+	}
+}
+
+// boundsByField indexes p.Key.Lower and p.Upper by field name for convenient per-field lookups.
+func (p *Partition) boundsByField() (lower, upper map[string]interface{}) {
+	lower = make(map[string]interface{}, len(p.Key.Lower))
+	for _, e := range p.Key.Lower {
+		lower[e.Key] = e.Value
+	}
+	upper = make(map[string]interface{}, len(p.Upper))
+	for _, e := range p.Upper {
+		upper[e.Key] = e.Value
+	}
+	return lower, upper
+}
+
+// exprFilter implements BoundExpr: the key fields are compared as a single tuple, via $expr on
+// two array literals. MongoDB's comparison operators order arrays lexicographically (element by
+// element, first difference wins), so comparing `["$a", "$b", ...]` against the literal lower and
+// upper tuples is exactly a lexicographic range check over a compound key — the same semantics a
+// sharded chunk's [min, max) boundary has. $literal guards against MQL injection from a bound's
+// value. Always correct, but $expr disables index bounds on many server versions.
+func (p *Partition) exprFilter() bson.D {
+	fields := p.Key.fields()
+	lowerByField, upperByField := p.boundsByField()
+
+	fieldRefs := make(bson.A, 0, len(fields))
+	lowerTuple := make(bson.A, 0, len(fields))
+	upperTuple := make(bson.A, 0, len(fields))
+	for _, field := range fields {
+		fieldRefs = append(fieldRefs, "$"+field)
+		lowerTuple = append(lowerTuple, lowerByField[field])
+		upperTuple = append(upperTuple, upperByField[field])
+	}
+
+	upperOp := "$lte"
+	if p.UpperExclusive {
+		upperOp = "$lt"
+	}
+
+	return bson.D{{"$expr", bson.D{
+		{"$and", bson.A{
+			// The (field...) tuple, as an array, is >= the lower bound tuple.
+			bson.D{{"$gte", bson.A{fieldRefs, bson.D{{"$literal", lowerTuple}}}}},
+			// The (field...) tuple is <=/< the upper bound tuple.
+			bson.D{{upperOp, bson.A{fieldRefs, bson.D{{"$literal", upperTuple}}}}},
+		}},
+	}}}
+}
+
+// nativeFilter implements BoundNative: a plain range query using the standard nested-$or
+// expansion for compound-key lexicographic comparisons, letting the server use index bounds. Only
+// safe to call when every bound shares a single BSON type, since native comparison is subject to
+// type bracketing.
+func (p *Partition) nativeFilter() bson.D {
+	fields := p.Key.fields()
+	lowerByField, upperByField := p.boundsByField()
+
+	lowerValues := make([]interface{}, len(fields))
+	upperValues := make([]interface{}, len(fields))
+	for i, field := range fields {
+		lowerValues[i] = lowerByField[field]
+		upperValues[i] = upperByField[field]
+	}
+
+	if len(fields) == 1 {
+		upperOp := "$lte"
+		if p.UpperExclusive {
+			upperOp = "$lt"
+		}
+		return bson.D{{fields[0], bson.D{
+			{"$gte", lowerValues[0]},
+			{upperOp, upperValues[0]},
+		}}}
+	}
+
 	return bson.D{{"$and", bson.A{
-		// All _id values >= lower bound.
-		bson.D{{"$expr", bson.D{
-			{"$gte", bson.A{
-				"$_id",
-				bson.D{{"$literal", p.Key.Lower}},
-			}},
+		lexicographicGTE(fields, lowerValues),
+		lexicographicLTE(fields, upperValues, !p.UpperExclusive),
+	}}}
+}
+
+// lexicographicGTE returns a filter matching documents whose (fields...) tuple is
+// lexicographically >= values...: the first field is strictly greater, or it's equal and the
+// remaining fields satisfy the same condition. This is the standard nested-$or expansion MongoDB
+// itself uses internally for compound-index range queries (e.g. chunk boundaries).
+func lexicographicGTE(fields []string, values []interface{}) bson.D {
+	if len(fields) == 1 {
+		return bson.D{{fields[0], bson.D{{"$gte", values[0]}}}}
+	}
+	return bson.D{{"$or", bson.A{
+		bson.D{{fields[0], bson.D{{"$gt", values[0]}}}},
+		bson.D{{"$and", bson.A{
+			bson.D{{fields[0], values[0]}},
+			lexicographicGTE(fields[1:], values[1:]),
 		}}},
-		// All _id values <= upper bound.
-		bson.D{{"$expr", bson.D{
-			{"$lte", bson.A{
-				"$_id",
-				bson.D{{"$literal", p.Upper}},
-			}},
+	}}}
+}
+
+// lexicographicLTE returns a filter matching documents whose (fields...) tuple is
+// lexicographically <= values... (or, with inclusive=false, strictly < values...). See
+// lexicographicGTE.
+func lexicographicLTE(fields []string, values []interface{}, inclusive bool) bson.D {
+	if len(fields) == 1 {
+		op := "$lte"
+		if !inclusive {
+			op = "$lt"
+		}
+		return bson.D{{fields[0], bson.D{{op, values[0]}}}}
+	}
+	return bson.D{{"$or", bson.A{
+		bson.D{{fields[0], bson.D{{"$lt", values[0]}}}},
+		bson.D{{"$and", bson.A{
+			bson.D{{fields[0], values[0]}},
+			lexicographicLTE(fields[1:], values[1:], inclusive),
 		}}},
 	}}}
 }
+
+// typeBracketedFilter implements BoundTypeBracketed. Type bracketing is only meaningful for a
+// single scalar key field; compound keys (e.g. a multi-field shard key) fall back to the same
+// lexicographic tuple comparison as BoundNative, since their bounds come from chunk boundaries
+// that always share a type per field in practice.
+//
+// For a single field, when the lower and upper bound share a BSON type, this degrades to the same
+// native range as nativeFilter. When they don't, it emits an $or of the native range against each
+// bound's own type, plus a $type match for any BSON type that sorts strictly between the two in
+// the server's BSON comparison order (bsonComparisonOrder) — those types are admitted in full,
+// since every value of a strictly-between type is already known to fall within [L, U]. This stays
+// index-bound-eligible for mixed-type partitions, unlike BoundExpr.
+func (p *Partition) typeBracketedFilter() bson.D {
+	fields := p.Key.fields()
+	if len(fields) > 1 {
+		return p.nativeFilter()
+	}
+
+	lowerByField, upperByField := p.boundsByField()
+	field := fields[0]
+	lower, upper := lowerByField[field], upperByField[field]
+
+	upperOp := "$lte"
+	if p.UpperExclusive {
+		upperOp = "$lt"
+	}
+
+	lowerType, upperType := bsonType(lower), bsonType(upper)
+	if lowerType == upperType {
+		return bson.D{{field, bson.D{
+			{"$gte", lower},
+			{upperOp, upper},
+		}}}
+	}
+
+	between := bsonTypesBetween(lowerType, upperType)
+	or := bson.A{
+		bson.D{{"$and", bson.A{
+			bson.D{{field, bson.D{{"$gte", lower}}}},
+			bson.D{{field, bson.D{{"$type", lowerType}}}},
+		}}},
+		bson.D{{"$and", bson.A{
+			bson.D{{field, bson.D{{upperOp, upper}}}},
+			bson.D{{field, bson.D{{"$type", upperType}}}},
+		}}},
+	}
+	if len(between) > 0 {
+		or = append(or, bson.D{{field, bson.D{{"$type", between}}}})
+	}
+
+	return bson.D{{"$or", or}}
+}
+
+// bsonComparisonOrder lists BSON type alias strings (as accepted by the `$type` operator) in the
+// server's canonical comparison order, from lowest-sorting to highest-sorting, starting with
+// MinKey and ending with MaxKey, which sort below and above every other type respectively.
+var bsonComparisonOrder = []string{
+	"minKey",
+	"null",
+	"number",
+	"string",
+	"object",
+	"array",
+	"binData",
+	"objectId",
+	"bool",
+	"date",
+	"timestamp",
+	"regex",
+	"maxKey",
+}
+
+// bsonType returns the `$type` alias for v's BSON type, for use in typeBracketedFilter.
+func bsonType(v interface{}) string {
+	switch v.(type) {
+	case int32, int64, float64, primitive.Decimal128:
+		return "number"
+	case string:
+		return "string"
+	case bson.D, bson.M:
+		return "object"
+	case bson.A:
+		return "array"
+	case primitive.Binary:
+		return "binData"
+	case primitive.ObjectID:
+		return "objectId"
+	case bool:
+		return "bool"
+	case primitive.DateTime:
+		return "date"
+	case primitive.Timestamp:
+		return "timestamp"
+	case primitive.Regex:
+		return "regex"
+	case primitive.MinKey:
+		return "minKey"
+	case primitive.MaxKey:
+		return "maxKey"
+	case nil:
+		return "null"
+	default:
+		return "object"
+	}
+}
+
+// bsonTypesBetween returns every type alias in bsonComparisonOrder that sorts strictly between
+// lowerType and upperType.
+func bsonTypesBetween(lowerType, upperType string) []string {
+	lowerIdx, upperIdx := -1, -1
+	for i, t := range bsonComparisonOrder {
+		if t == lowerType {
+			lowerIdx = i
+		}
+		if t == upperType {
+			upperIdx = i
+		}
+	}
+	if lowerIdx == -1 || upperIdx == -1 || upperIdx <= lowerIdx+1 {
+		return nil
+	}
+	return bsonComparisonOrder[lowerIdx+1 : upperIdx]
+}
+
+// chooseBoundStrategy picks BoundNative when every field's lower and upper bound provably share
+// a single BSON type (the common case for ObjectId `_id`s), falling back to BoundExpr for
+// mixed-type partitions.
+func chooseBoundStrategy(lower, upper bson.D) BoundStrategy {
+	if len(lower) != len(upper) {
+		return BoundExpr
+	}
+
+	upperByField := make(map[string]interface{}, len(upper))
+	for _, e := range upper {
+		upperByField[e.Key] = e.Value
+	}
+
+	for _, e := range lower {
+		u, ok := upperByField[e.Key]
+		if !ok || bsonType(e.Value) != bsonType(u) {
+			return BoundExpr
+		}
+	}
+
+	return BoundNative
+}
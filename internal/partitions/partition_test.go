@@ -0,0 +1,304 @@
+package partitions
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func objID(hex string) primitive.ObjectID {
+	id, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+func TestChooseBoundStrategy(t *testing.T) {
+	oid1 := objID("000000000000000000000001")
+	oid2 := objID("000000000000000000000002")
+
+	tests := []struct {
+		name  string
+		lower bson.D
+		upper bson.D
+		want  BoundStrategy
+	}{
+		{
+			name:  "same type single field",
+			lower: bson.D{{"_id", oid1}},
+			upper: bson.D{{"_id", oid2}},
+			want:  BoundNative,
+		},
+		{
+			name:  "mixed type single field",
+			lower: bson.D{{"_id", int32(1)}},
+			upper: bson.D{{"_id", oid2}},
+			want:  BoundExpr,
+		},
+		{
+			name: "same type compound key",
+			lower: bson.D{
+				{"region", "us"},
+				{"_id", oid1},
+			},
+			upper: bson.D{
+				{"region", "us"},
+				{"_id", oid2},
+			},
+			want: BoundNative,
+		},
+		{
+			name: "mixed type compound key falls back to expr",
+			lower: bson.D{
+				{"region", "us"},
+				{"_id", int32(1)},
+			},
+			upper: bson.D{
+				{"region", "us"},
+				{"_id", oid2},
+			},
+			want: BoundExpr,
+		},
+		{
+			name:  "mismatched field counts falls back to expr",
+			lower: bson.D{{"_id", oid1}},
+			upper: bson.D{{"_id", oid2}, {"extra", 1}},
+			want:  BoundExpr,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := chooseBoundStrategy(tt.lower, tt.upper); got != tt.want {
+				t.Errorf("chooseBoundStrategy(%v, %v) = %v, want %v", tt.lower, tt.upper, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBsonTypesBetween(t *testing.T) {
+	tests := []struct {
+		name      string
+		lowerType string
+		upperType string
+		want      []string
+	}{
+		{
+			name:      "adjacent types have nothing between",
+			lowerType: "number",
+			upperType: "string",
+			want:      nil,
+		},
+		{
+			name:      "types separated by one type",
+			lowerType: "number",
+			upperType: "object",
+			want:      []string{"string"},
+		},
+		{
+			name:      "types separated by several types",
+			lowerType: "null",
+			upperType: "bool",
+			want:      []string{"number", "string", "object", "array", "binData", "objectId"},
+		},
+		{
+			name:      "reversed order yields nothing",
+			lowerType: "bool",
+			upperType: "null",
+			want:      nil,
+		},
+		{
+			name:      "unknown type yields nothing",
+			lowerType: "number",
+			upperType: "notAType",
+			want:      nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := bsonTypesBetween(tt.lowerType, tt.upperType)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("bsonTypesBetween(%q, %q) = %v, want %v", tt.lowerType, tt.upperType, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeSmallBuckets(t *testing.T) {
+	bucket := func(min, max interface{}, count int) bucketAutoBucket {
+		var b bucketAutoBucket
+		b.ID.Min = min
+		b.ID.Max = max
+		b.Count = count
+		return b
+	}
+
+	tests := []struct {
+		name    string
+		buckets []bucketAutoBucket
+		want    []idBoundary
+	}{
+		{
+			name: "every bucket above threshold stays separate",
+			buckets: []bucketAutoBucket{
+				bucket(1, 2, minPartitionDocCount),
+				bucket(2, 3, minPartitionDocCount),
+			},
+			want: []idBoundary{
+				{lower: 1, upper: 2},
+				{lower: 2, upper: 3},
+			},
+		},
+		{
+			name: "small tail bucket merges into previous",
+			buckets: []bucketAutoBucket{
+				bucket(1, 2, minPartitionDocCount),
+				bucket(2, 3, minPartitionDocCount-1),
+			},
+			want: []idBoundary{
+				{lower: 1, upper: 3},
+			},
+		},
+		{
+			name: "small leading bucket has nothing to merge into and is kept",
+			buckets: []bucketAutoBucket{
+				bucket(1, 2, minPartitionDocCount-1),
+				bucket(2, 3, minPartitionDocCount),
+			},
+			want: []idBoundary{
+				{lower: 1, upper: 2},
+				{lower: 2, upper: 3},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mergeSmallBuckets(tt.buckets)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeSmallBuckets() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// filterString marshals p's filter for the given strategy to extended JSON so tests can make
+// cheap substring assertions without hand-building the expected bson.D shape for every operator
+// nesting.
+func filterString(t *testing.T, p *Partition, strategy BoundStrategy) string {
+	t.Helper()
+	raw, err := bson.MarshalExtJSON(p.filter(strategy), false, false)
+	if err != nil {
+		t.Fatalf("strategy %v: failed to marshal filter: %v", strategy, err)
+	}
+	return string(raw)
+}
+
+func TestPartitionFilter_CompoundKeyIsLexicographic(t *testing.T) {
+	// Regression test: a chunk range like min={a:1,b:100}, max={a:2,b:5} is a lexicographic range
+	// over the (a, b) tuple, not an independent box over a and b. The bug this guards against
+	// ANDed independent per-field ranges together (b >= 100 AND b <= 5), which matches nothing.
+	// A correct BoundExpr filter compares the ["$a", "$b"] tuple as a whole; a correct native
+	// filter expands the tuple into nested $or branches over "a" and "b".
+	p := &Partition{
+		Ns: &Namespace{DB: "test", Coll: "coll"},
+		Key: PartitionKey{
+			KeyFields: []string{"a", "b"},
+			Lower:     bson.D{{"a", int32(1)}, {"b", int32(100)}},
+		},
+		Upper: bson.D{{"a", int32(2)}, {"b", int32(5)}},
+	}
+
+	for _, strategy := range []BoundStrategy{BoundExpr, BoundNative, BoundTypeBracketed} {
+		s := filterString(t, p, strategy)
+		if strategy == BoundExpr {
+			if !strings.Contains(s, `["$a","$b"]`) {
+				t.Errorf("strategy %v: filter doesn't compare the (a, b) tuple as a whole: %s", strategy, s)
+			}
+			continue
+		}
+		if !strings.Contains(s, `"a"`) {
+			t.Errorf("strategy %v: filter doesn't reference field \"a\" at all: %s", strategy, s)
+		}
+		if !strings.Contains(s, `"b"`) {
+			t.Errorf("strategy %v: filter doesn't reference field \"b\" at all: %s", strategy, s)
+		}
+		if !strings.Contains(s, `"$or"`) {
+			t.Errorf("strategy %v: filter isn't a nested-$or lexicographic expansion: %s", strategy, s)
+		}
+	}
+}
+
+func TestPartitionFilter_UpperExclusiveUsesStrictOperator(t *testing.T) {
+	p := &Partition{
+		Ns: &Namespace{DB: "test", Coll: "coll"},
+		Key: PartitionKey{
+			KeyFields: []string{"_id"},
+			Lower:     bson.D{{"_id", int32(1)}},
+		},
+		Upper:          bson.D{{"_id", int32(2)}},
+		UpperExclusive: true,
+	}
+
+	for _, strategy := range []BoundStrategy{BoundExpr, BoundNative, BoundTypeBracketed} {
+		s := filterString(t, p, strategy)
+		if strings.Contains(s, `"$lte"`) {
+			t.Errorf("strategy %v: expected a strict $lt for an exclusive upper bound, got: %s", strategy, s)
+		}
+		if !strings.Contains(s, `"$lt"`) {
+			t.Errorf("strategy %v: expected a $lt for the exclusive upper bound, got: %s", strategy, s)
+		}
+	}
+}
+
+func TestPartitionFilter_InclusiveUpperUsesLte(t *testing.T) {
+	p := &Partition{
+		Ns: &Namespace{DB: "test", Coll: "coll"},
+		Key: PartitionKey{
+			KeyFields: []string{"_id"},
+			Lower:     bson.D{{"_id", int32(1)}},
+		},
+		Upper: bson.D{{"_id", int32(2)}},
+	}
+
+	for _, strategy := range []BoundStrategy{BoundExpr, BoundNative, BoundTypeBracketed} {
+		s := filterString(t, p, strategy)
+		if !strings.Contains(s, `"$lte"`) {
+			t.Errorf("strategy %v: expected $lte for an inclusive upper bound, got: %s", strategy, s)
+		}
+	}
+}
+
+func BenchmarkPartitionFilter(b *testing.B) {
+	p := &Partition{
+		Ns: &Namespace{DB: "test", Coll: "coll"},
+		Key: PartitionKey{
+			KeyFields: []string{"_id"},
+			Lower:     bson.D{{"_id", objID("000000000000000000000001")}},
+		},
+		Upper: bson.D{{"_id", objID("000000000000000000000002")}},
+	}
+
+	strategies := []struct {
+		name     string
+		strategy BoundStrategy
+	}{
+		{"BoundExpr", BoundExpr},
+		{"BoundNative", BoundNative},
+		{"BoundTypeBracketed", BoundTypeBracketed},
+	}
+
+	for _, s := range strategies {
+		b.Run(s.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_ = p.filter(s.strategy)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package partitions
+
+import (
+	"context"
+
+	"github.com/10gen/migration-verifier/internal/util"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// minPartitionDocCount is the smallest number of documents we're willing to carve off into their
+// own partition. Below this, the planner merges the tail into the previous partition rather than
+// emit a partition that's barely worth a worker's time.
+const minPartitionDocCount = 1000
+
+// PartitionByTargetCount samples the `_id` distribution of ns and returns up to desiredPartitions
+// Partitions whose bounds approximately equalize document counts, in the spirit of Firestore's
+// PartitionQuery: callers ask for N partitions, the planner may return fewer when the collection
+// is too small to support N non-trivial partitions, and the returned partitions are disjoint,
+// sorted by Lower, and together cover (minKey, maxKey].
+//
+// This lets an orchestrator size verification work to its worker pool rather than to a fixed
+// byte/document target.
+func PartitionByTargetCount(
+	ctx context.Context,
+	coll *mongo.Collection,
+	dstColl *mongo.Collection,
+	ns Namespace,
+	desiredPartitions int,
+) ([]*Partition, error) {
+	if desiredPartitions < 1 {
+		return nil, errors.Errorf("desiredPartitions must be positive, got %d", desiredPartitions)
+	}
+
+	srcUUID, err := getCollectionUUID(ctx, coll)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get source collection UUID for %s.%s", ns.DB, ns.Coll)
+	}
+
+	dstUUID, err := getCollectionUUID(ctx, dstColl)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get destination collection UUID for %s.%s", ns.DB, ns.Coll)
+	}
+
+	boundaries, err := sampleIDBoundaries(ctx, coll, desiredPartitions)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to sample _id boundaries for %s.%s", ns.DB, ns.Coll)
+	}
+
+	partitions := make([]*Partition, 0, len(boundaries))
+	for i, b := range boundaries {
+		lowerVal, upperVal := b.lower, b.upper
+		// Widen the outermost bounds to MinKey/MaxKey so the partitions cover every possible
+		// _id, not just the range sampled at planning time.
+		if i == 0 {
+			lowerVal = primitive.MinKey{}
+		}
+		if i == len(boundaries)-1 {
+			upperVal = primitive.MaxKey{}
+		}
+		lower := bson.D{{"_id", lowerVal}}
+		upper := bson.D{{"_id", upperVal}}
+		partitions = append(partitions, &Partition{
+			Key: PartitionKey{
+				SourceUUID:  srcUUID,
+				DestUUID:    dstUUID,
+				Lower:       lower,
+				KeyFields:   []string{"_id"},
+				MongosyncID: primitive.NewObjectID().Hex(),
+			},
+			Ns:    &ns,
+			Upper: upper,
+			// Consecutive $bucketAuto buckets share a boundary value (boundaries[i].upper ==
+			// boundaries[i+1].lower); exclude it from every partition but the last so the
+			// boundary document isn't scanned by both.
+			UpperExclusive: i < len(boundaries)-1,
+			Strategy:       chooseBoundStrategy(lower, upper),
+		})
+	}
+
+	return partitions, nil
+}
+
+type idBoundary struct {
+	lower interface{}
+	upper interface{}
+}
+
+// bucketAutoBucket mirrors one document of $bucketAuto's output.
+type bucketAutoBucket struct {
+	ID struct {
+		Min interface{} `bson:"min"`
+		Max interface{} `bson:"max"`
+	} `bson:"_id"`
+	Count int `bson:"count"`
+}
+
+// sampleIDBoundaries uses $bucketAuto on _id to pick up to desiredPartitions boundary values in a
+// single pass over the collection. Buckets with trivially few documents are merged into their
+// neighbor so we never emit a partition not worth handing to a worker.
+func sampleIDBoundaries(ctx context.Context, coll *mongo.Collection, desiredPartitions int) ([]idBoundary, error) {
+	pipeline := mongo.Pipeline{
+		{{"$bucketAuto", bson.D{
+			{"groupBy", "$_id"},
+			{"buckets", desiredPartitions},
+		}}},
+	}
+
+	cursor, err := coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, errors.Wrap(err, "$bucketAuto aggregation failed")
+	}
+	defer cursor.Close(ctx)
+
+	var buckets []bucketAutoBucket
+	if err := cursor.All(ctx, &buckets); err != nil {
+		return nil, errors.Wrap(err, "failed to decode $bucketAuto output")
+	}
+
+	return mergeSmallBuckets(buckets), nil
+}
+
+// mergeSmallBuckets converts raw $bucketAuto buckets into idBoundary values, merging any bucket
+// with fewer than minPartitionDocCount documents into the previous partition rather than emitting
+// a partition not worth handing to a worker. It never merges the first bucket away, since there's
+// no previous partition to absorb it into.
+func mergeSmallBuckets(buckets []bucketAutoBucket) []idBoundary {
+	boundaries := make([]idBoundary, 0, len(buckets))
+	for _, b := range buckets {
+		if b.Count < minPartitionDocCount && len(boundaries) > 0 {
+			boundaries[len(boundaries)-1].upper = b.ID.Max
+			continue
+		}
+		boundaries = append(boundaries, idBoundary{lower: b.ID.Min, upper: b.ID.Max})
+	}
+
+	return boundaries
+}
+
+// Merge combines p and other into a single partition spanning both of their bounds, keeping p's
+// MongosyncID. The two partitions must be adjacent (other.Key.Lower immediately follows p.Upper)
+// and belong to the same namespace with the same key fields; callers are responsible for ordering
+// them correctly.
+func (p *Partition) Merge(other *Partition) *Partition {
+	return &Partition{
+		Key: PartitionKey{
+			SourceUUID:  p.Key.SourceUUID,
+			DestUUID:    p.Key.DestUUID,
+			Lower:       p.Key.Lower,
+			KeyFields:   p.Key.KeyFields,
+			MongosyncID: p.Key.MongosyncID,
+		},
+		Ns:             p.Ns,
+		Upper:          other.Upper,
+		UpperExclusive: other.UpperExclusive,
+		Strategy:       chooseBoundStrategy(p.Key.Lower, other.Upper),
+	}
+}
+
+// Split divides p into len(boundaries)+1 sub-partitions, cut at the given sorted
+// interior boundary values, each a bson.D over p.Key.fields() that falls strictly between
+// p.Key.Lower and p.Upper. Every sub-partition keeps p's MongosyncID, since they're all still
+// part of the same logical partition, just subdivided.
+func (p *Partition) Split(boundaries []bson.D) []*Partition {
+	bounds := make([]bson.D, 0, len(boundaries)+2)
+	bounds = append(bounds, p.Key.Lower)
+	bounds = append(bounds, boundaries...)
+	bounds = append(bounds, p.Upper)
+
+	result := make([]*Partition, 0, len(bounds)-1)
+	for i := 0; i < len(bounds)-1; i++ {
+		result = append(result, &Partition{
+			Key: PartitionKey{
+				SourceUUID:  p.Key.SourceUUID,
+				DestUUID:    p.Key.DestUUID,
+				Lower:       bounds[i],
+				KeyFields:   p.Key.KeyFields,
+				MongosyncID: p.Key.MongosyncID,
+			},
+			Ns:    p.Ns,
+			Upper: bounds[i+1],
+			// Interior cut points are new shared boundaries between adjacent sub-partitions, so
+			// they need the same exclusive-upper treatment as any other adjacent pair; only the
+			// final sub-partition inherits p's own (already-correct) upper exclusivity.
+			UpperExclusive: i < len(bounds)-2 || p.UpperExclusive,
+			Strategy:       chooseBoundStrategy(bounds[i], bounds[i+1]),
+		})
+	}
+
+	return result
+}
+
+// getCollectionUUID fetches coll's UUID via listCollections. Callers pin the result in either
+// PartitionKey.SourceUUID or PartitionKey.DestUUID, depending on which side coll is on.
+func getCollectionUUID(ctx context.Context, coll *mongo.Collection) (id util.UUID, err error) {
+	db := coll.Database()
+	cursor, err := db.ListCollections(ctx, bson.D{{"name", coll.Name()}})
+	if err != nil {
+		return id, errors.Wrap(err, "listCollections failed")
+	}
+	defer cursor.Close(ctx)
+
+	var result struct {
+		Info struct {
+			UUID util.UUID `bson:"uuid"`
+		} `bson:"info"`
+	}
+	if !cursor.Next(ctx) {
+		return id, errors.Errorf("collection %q not found", coll.Name())
+	}
+	if err := cursor.Decode(&result); err != nil {
+		return id, errors.Wrap(err, "failed to decode listCollections result")
+	}
+
+	return result.Info.UUID, nil
+}
@@ -0,0 +1,155 @@
+package partitions
+
+import (
+	"context"
+
+	"github.com/10gen/migration-verifier/internal/util"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// configChunk mirrors the subset of a config.chunks document that PartitionsByChunks needs. Shard
+// key bounds come back as bson.D so that field order (and therefore key order) is preserved.
+type configChunk struct {
+	Min bson.D `bson:"min"`
+	Max bson.D `bson:"max"`
+}
+
+// configCollection mirrors the subset of a config.collections document that PartitionsByChunks
+// needs to resolve a sharded namespace's key fields and, on MongoDB 5.0+, the UUID that
+// config.chunks is keyed by.
+type configCollection struct {
+	Key  bson.D    `bson:"key"`
+	UUID util.UUID `bson:"uuid"`
+}
+
+// PartitionsByChunks builds one Partition per chunk of a sharded collection, aligning partition
+// boundaries to the collection's shard key rather than `_id`. This produces partitions that match
+// the server's own chunk boundaries, so reads hit the right shard key range and the find's
+// `hint()` lines up with the shard key index instead of forcing an `{_id: 1}` scan.
+//
+// Capped collections cannot be sharded, so their handling is unchanged: callers should continue
+// to use a single capped Partition rather than calling this function.
+func PartitionsByChunks(
+	ctx context.Context,
+	srcClient *mongo.Client,
+	coll *mongo.Collection,
+	dstColl *mongo.Collection,
+	ns Namespace,
+) ([]*Partition, error) {
+	srcUUID, err := getCollectionUUID(ctx, coll)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get source collection UUID for %s.%s", ns.DB, ns.Coll)
+	}
+
+	dstUUID, err := getCollectionUUID(ctx, dstColl)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get destination collection UUID for %s.%s", ns.DB, ns.Coll)
+	}
+
+	collMeta, err := getConfigCollection(ctx, srcClient, ns)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to determine shard key for %s.%s", ns.DB, ns.Coll)
+	}
+
+	keyFields := make([]string, 0, len(collMeta.Key))
+	for _, e := range collMeta.Key {
+		if e.Value == "hashed" {
+			return nil, errors.Errorf(
+				"sharded collection %s.%s has a hashed shard key field %q; chunk bounds are hashes of "+
+					"the field's value, not range bounds on it, so migration-verifier cannot partition "+
+					"this collection by chunk",
+				ns.DB, ns.Coll, e.Key)
+		}
+		keyFields = append(keyFields, e.Key)
+	}
+
+	chunks, err := listChunks(ctx, srcClient, ns, collMeta.UUID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list chunks for %s.%s", ns.DB, ns.Coll)
+	}
+	if len(chunks) == 0 {
+		return nil, errors.Errorf("sharded collection %s.%s has no chunks in config.chunks", ns.DB, ns.Coll)
+	}
+
+	partitions := make([]*Partition, 0, len(chunks))
+	for i, chunk := range chunks {
+		partitions = append(partitions, &Partition{
+			Key: PartitionKey{
+				SourceUUID:  srcUUID,
+				DestUUID:    dstUUID,
+				Lower:       chunk.Min,
+				KeyFields:   keyFields,
+				MongosyncID: primitive.NewObjectID().Hex(),
+			},
+			Ns:    &ns,
+			Upper: chunk.Max,
+			// Chunks are contiguous, half-open ranges: chunk i's max equals chunk i+1's min.
+			// Exclude the upper bound on every chunk but the last so consecutive partitions
+			// don't both claim the boundary value.
+			UpperExclusive: i < len(chunks)-1,
+			Strategy:       chooseBoundStrategy(chunk.Min, chunk.Max),
+		})
+	}
+
+	return partitions, nil
+}
+
+// getConfigCollection reads ns's sharding metadata — its shard key and (on MongoDB 5.0+) its
+// UUID — from config.collections.
+func getConfigCollection(ctx context.Context, client *mongo.Client, ns Namespace) (configCollection, error) {
+	var result configCollection
+
+	err := client.Database("config").Collection("collections").
+		FindOne(ctx, bson.D{{"_id", ns.DB + "." + ns.Coll}}).
+		Decode(&result)
+	if err != nil {
+		return result, errors.Wrap(err, "failed to read collection metadata from config.collections")
+	}
+
+	return result, nil
+}
+
+// listChunks reads every chunk for ns from config.chunks, sorted by min so the resulting
+// Partitions are already in Lower order.
+//
+// On MongoDB 5.0+, config.chunks is keyed by the collection's UUID rather than its namespace
+// string, so a namespace rename or drop/recreate doesn't orphan its chunk documents. We therefore
+// query by uuid first and only fall back to the legacy `ns` field (pre-5.0, or if the UUID lookup
+// comes back empty) so this keeps working against older servers.
+func listChunks(ctx context.Context, client *mongo.Client, ns Namespace, collUUID util.UUID) ([]configChunk, error) {
+	chunksColl := client.Database("config").Collection("chunks")
+	findOpts := options.Find().SetSort(bson.D{{"min", 1}})
+
+	var empty util.UUID
+	if collUUID != empty {
+		chunks, err := findChunks(ctx, chunksColl, bson.D{{"uuid", collUUID}}, findOpts)
+		if err != nil {
+			return nil, err
+		}
+		if len(chunks) > 0 {
+			return chunks, nil
+		}
+	}
+
+	return findChunks(ctx, chunksColl, bson.D{{"ns", ns.DB + "." + ns.Coll}}, findOpts)
+}
+
+// findChunks runs the given config.chunks filter and decodes the matching chunk documents.
+func findChunks(ctx context.Context, chunksColl *mongo.Collection, filter bson.D, findOpts *options.FindOptions) ([]configChunk, error) {
+	cursor, err := chunksColl.Find(ctx, filter, findOpts)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query config.chunks")
+	}
+	defer cursor.Close(ctx)
+
+	var chunks []configChunk
+	if err := cursor.All(ctx, &chunks); err != nil {
+		return nil, errors.Wrap(err, "failed to decode config.chunks documents")
+	}
+
+	return chunks, nil
+}
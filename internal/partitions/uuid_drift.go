@@ -0,0 +1,130 @@
+package partitions
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/10gen/migration-verifier/internal/logger"
+	"github.com/10gen/migration-verifier/internal/util"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// collectionUUIDMismatchCode is the server error code for CollectionUUIDMismatch, returned when a
+// command's `collectionUUID` argument doesn't match the collection's current UUID.
+const collectionUUIDMismatchCode = 235
+
+// side identifies which endpoint a UUID drift was observed on.
+type side string
+
+const (
+	sourceSide      side = "source"
+	destinationSide side = "destination"
+)
+
+// ErrCollectionUUIDChanged indicates that a collection's UUID no longer matches the UUID recorded
+// when its Partition was planned, which means the collection was dropped and recreated (or
+// renamed into place) mid-run on the named side.
+type ErrCollectionUUIDChanged struct {
+	Ns       Namespace
+	Side     side
+	Expected util.UUID
+	Actual   util.UUID
+}
+
+func (e *ErrCollectionUUIDChanged) Error() string {
+	return fmt.Sprintf(
+		"%s collection %s.%s UUID changed: expected %s, found %s",
+		e.Side, e.Ns.DB, e.Ns.Coll, e.Expected, e.Actual)
+}
+
+// Verify confirms that the source and destination collections for p still have the UUIDs that
+// were recorded when p was planned, issuing a `listCollections` call against each client. It
+// returns an *ErrCollectionUUIDChanged if either side has been dropped and recreated since
+// planning, making it unsafe to continue verifying this partition.
+func (p *Partition) Verify(ctx context.Context, srcClient, dstClient *mongo.Client) error {
+	srcUUID, err := getCollectionUUID(ctx, srcClient.Database(p.Ns.DB).Collection(p.Ns.Coll))
+	if err != nil {
+		return errors.Wrapf(err, "failed to get source collection UUID for %s.%s", p.Ns.DB, p.Ns.Coll)
+	}
+	if srcUUID != p.Key.SourceUUID {
+		return &ErrCollectionUUIDChanged{
+			Ns:       *p.Ns,
+			Side:     sourceSide,
+			Expected: p.Key.SourceUUID,
+			Actual:   srcUUID,
+		}
+	}
+
+	dstUUID, err := getCollectionUUID(ctx, dstClient.Database(p.Ns.DB).Collection(p.Ns.Coll))
+	if err != nil {
+		return errors.Wrapf(err, "failed to get destination collection UUID for %s.%s", p.Ns.DB, p.Ns.Coll)
+	}
+	if dstUUID != p.Key.DestUUID {
+		return &ErrCollectionUUIDChanged{
+			Ns:       *p.Ns,
+			Side:     destinationSide,
+			Expected: p.Key.DestUUID,
+			Actual:   dstUUID,
+		}
+	}
+
+	return nil
+}
+
+// Find runs p.FindCmd against srcClient and returns the resulting cursor. This is the find loop's
+// single entry point into the server, so it's where a CollectionUUIDMismatch server error (code
+// 235) — the source collection having been dropped and recreated since p was planned — gets
+// translated into the same *ErrCollectionUUIDChanged that Verify returns, letting callers handle
+// both detection paths identically.
+func (p *Partition) Find(
+	ctx context.Context,
+	srcClient *mongo.Client,
+	log *logger.Logger,
+	startAt *primitive.Timestamp,
+	resumeAfter bson.D,
+	strategyOverride *BoundStrategy,
+	batchSize ...int,
+) (*mongo.Cursor, error) {
+	cmd := p.FindCmd(log, startAt, resumeAfter, strategyOverride, batchSize...)
+
+	cursor, err := srcClient.Database(p.Ns.DB).RunCommandCursor(ctx, cmd)
+	if err != nil {
+		return nil, p.translateFindError(ctx, srcClient, err)
+	}
+
+	return cursor, nil
+}
+
+// translateFindError inspects an error returned from running p.FindCmd and, if it's a
+// CollectionUUIDMismatch server error (code 235), translates it into an *ErrCollectionUUIDChanged
+// carrying the expected and actual UUIDs so callers can react the same way they would to a
+// UUID drift caught by Verify.
+//
+// The mismatch error's errInfo.collectionUUID is the UUID the command itself supplied (i.e.
+// p.Key.SourceUUID, already known as Expected) and not the collection's current UUID, so it can't
+// be used to fill in Actual. Instead, a fresh listCollections call resolves the live UUID, the
+// same way Verify does.
+func (p *Partition) translateFindError(ctx context.Context, srcClient *mongo.Client, err error) error {
+	var cmdErr mongo.CommandError
+	if !errors.As(err, &cmdErr) || cmdErr.Code != collectionUUIDMismatchCode {
+		return err
+	}
+
+	actualUUID, uuidErr := getCollectionUUID(ctx, srcClient.Database(p.Ns.DB).Collection(p.Ns.Coll))
+	if uuidErr != nil {
+		// The collection may have been dropped outright rather than recreated, so a fresh UUID
+		// lookup can itself fail; that's still a definitive enough answer that p.Key.SourceUUID
+		// is stale to report the drift with an unset Actual rather than suppress it.
+		actualUUID = util.UUID{}
+	}
+
+	return &ErrCollectionUUIDChanged{
+		Ns:       *p.Ns,
+		Side:     sourceSide,
+		Expected: p.Key.SourceUUID,
+		Actual:   actualUUID,
+	}
+}